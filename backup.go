@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/linuxdeepin/deepin-ab-recovery/progress"
+	"github.com/linuxdeepin/deepin-ab-recovery/safepath"
+)
+
+// isSymlink reports whether p is itself a symlink (it does not follow
+// the link). Unlike backupExtraDir/restoreExtraDir's string-based public
+// signature (kept for t1_test.go compatibility, with safepath used only
+// internally), isSymlink takes a *safepath.Path directly: callers
+// checking an entry found while walking a safepath tree already have one
+// in hand, and going through safepath here is what keeps this check from
+// following a symlink swapped in after the walk observed it.
+func isSymlink(p *safepath.Path) (bool, error) {
+	return safepath.IsSymlinkAt(p)
+}
+
+// writeExcludeFile writes excludes, one per line, to a new temp file and
+// returns its name. It is used to feed an --exclude-from style list to
+// backup tooling. It keeps its plain-string signature rather than taking
+// a *safepath.Path: it only ever creates a brand new file under the
+// trusted system temp directory and writes locally-generated content to
+// it, so there is no attacker-influenced path for safepath to guard.
+func writeExcludeFile(excludes []string) (string, error) {
+	f, err := ioutil.TempFile("", "deepin-ab-recovery-exclude")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	for _, e := range excludes {
+		if _, err := f.WriteString(e + "\n"); err != nil {
+			return "", err
+		}
+	}
+	return f.Name(), nil
+}
+
+// getFileContent reads filename and returns its contents as a string.
+func getFileContent(filename string) (string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// backupExtraDir copies originDir (e.g. /var/lib/xyz) into hospiceDir,
+// under a subdirectory named after originDir's base name, so it can be
+// restored onto the standby slot later. Files listed in excludeFile (one
+// path per line, as produced by writeExcludeFile) are skipped.
+//
+// originDir is resolved with safepath so that a symlink planted anywhere
+// under it cannot be used to read files outside of originDir.
+//
+// p is variadic so existing callers that don't care about progress don't
+// need to change; when given, it receives one "backup" Event per entry
+// copied, directories and symlinks included.
+func backupExtraDir(originDir, excludeFile, hospiceDir string, p ...*progress.Progress) error {
+	excludes, err := readExcludeFile(excludeFile)
+	if err != nil {
+		return err
+	}
+
+	destDir := filepath.Join(hospiceDir, filepath.Base(originDir))
+	if err := os.MkdirAll(filepath.Dir(destDir), 0755); err != nil {
+		return err
+	}
+
+	srcParent := safepath.NewPath(filepath.Dir(originDir))
+	dstParent := safepath.NewPath(filepath.Dir(destDir))
+
+	src := srcParent.Join(filepath.Base(originDir))
+	dst := dstParent.Join(filepath.Base(destDir))
+
+	if err := os.RemoveAll(destDir); err != nil {
+		return err
+	}
+	return copyTreeExcluding(dst, src, excludes, progressOf(p), "backup")
+}
+
+// restoreExtraDir is the inverse of backupExtraDir: it relinks originDir
+// onto the backed up copy found under hospiceDir, again resolving every
+// path with safepath. Each live file is replaced by a symlink into the
+// hospice copy (rather than a byte-for-byte copy of it), so the restore
+// is instant regardless of originDir's size and any later edit to the
+// hospice copy keeps taking effect without a further restore call.
+func restoreExtraDir(originDir, excludeFile, hospiceDir string, p ...*progress.Progress) error {
+	excludes, err := readExcludeFile(excludeFile)
+	if err != nil {
+		return err
+	}
+
+	srcDir := filepath.Join(hospiceDir, filepath.Base(originDir))
+	if err := os.MkdirAll(originDir, 0755); err != nil {
+		return err
+	}
+
+	srcParent := safepath.NewPath(filepath.Dir(srcDir))
+	dstParent := safepath.NewPath(filepath.Dir(originDir))
+
+	src := srcParent.Join(filepath.Base(srcDir))
+	dst := dstParent.Join(filepath.Base(originDir))
+
+	return linkTreeExcluding(dst, src, excludes, progressOf(p), "restore")
+}
+
+// progressOf unwraps the variadic progress.Progress parameter used by
+// backupExtraDir/restoreExtraDir, returning nil when the caller passed
+// none.
+func progressOf(p []*progress.Progress) *progress.Progress {
+	if len(p) == 0 {
+		return nil
+	}
+	return p[0]
+}
+
+func readExcludeFile(excludeFile string) (map[string]bool, error) {
+	excludes := make(map[string]bool)
+	if excludeFile == "" {
+		return excludes, nil
+	}
+	content, err := getFileContent(excludeFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			excludes[line] = true
+		}
+	}
+	return excludes, nil
+}
+
+// copyTreeExcluding is safepath.CopyTree with a set of top-level relative
+// names skipped, matching the semantics an --exclude-from list had under
+// the old cp-based implementation. When p is non-nil, one Event is
+// reported under stage per entry copied, directories and symlinks
+// included.
+func copyTreeExcluding(dst, src *safepath.Path, excludes map[string]bool, p *progress.Progress, stage string) error {
+	var count int64
+	return safepath.CopyTreeFunc(dst, src, func(rel string) bool {
+		if excludes[rel] {
+			return false
+		}
+		if p != nil {
+			count++
+			p.Report(stage, count, 0, rel)
+		}
+		return true
+	})
+}
+
+// linkTreeExcluding walks src (a hospice copy made by copyTreeExcluding)
+// and replaces every corresponding entry under dst with a symlink into
+// src, skipping names in excludes. A src entry that is itself a symlink
+// (preserved verbatim by copyTreeExcluding) is restored as that same
+// symlink rather than a pointer back into src.
+func linkTreeExcluding(dst, src *safepath.Path, excludes map[string]bool, p *progress.Progress, stage string) error {
+	var count int64
+	return linkTreeRel(dst, src, "", excludes, p, &count, stage)
+}
+
+func linkTreeRel(dst, src *safepath.Path, rel string, excludes map[string]bool, p *progress.Progress, count *int64, stage string) error {
+	srcFile, err := safepath.OpenAt(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	entries, err := srcFile.Readdir(-1)
+	if err != nil {
+		return fmt.Errorf("backup: readdir %s: %w", src, err)
+	}
+
+	if err := safepath.MkdirAt(dst, 0755); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		entryRel := filepath.Join(rel, entry.Name())
+		if excludes[entryRel] {
+			continue
+		}
+		srcChild := src.Join(entry.Name())
+		dstChild := dst.Join(entry.Name())
+
+		if entry.Mode()&os.ModeSymlink != 0 {
+			target, err := safepath.ReadlinkAt(srcChild)
+			if err != nil {
+				return err
+			}
+			if err := replaceSymlinkAt(target, dstChild); err != nil {
+				return err
+			}
+		} else if entry.IsDir() {
+			if err := linkTreeRel(dstChild, srcChild, entryRel, excludes, p, count, stage); err != nil {
+				return err
+			}
+			continue
+		} else {
+			if err := replaceSymlinkAt(srcChild.String(), dstChild); err != nil {
+				return err
+			}
+		}
+
+		if p != nil {
+			*count++
+			p.Report(stage, *count, 0, entryRel)
+		}
+	}
+	return nil
+}
+
+// replaceSymlinkAt removes whatever is at p, if anything, and creates a
+// symlink to target in its place.
+func replaceSymlinkAt(target string, p *safepath.Path) error {
+	if err := safepath.UnlinkAt(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return safepath.SymlinkAt(target, p)
+}
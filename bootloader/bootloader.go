@@ -0,0 +1,88 @@
+// Package bootloader abstracts over the boot loaders deepin-ab-recovery
+// can drive to point the next boot at a given slot: grub on most x86/amd64
+// desktops, u-boot on the Loongson/ARM64 boards it also ships to, and
+// systemd-boot on UEFI-only images.
+package bootloader
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// Bootloader is the interface a boot loader backend must implement so the
+// rest of deepin-ab-recovery can switch slots without caring which one is
+// installed.
+type Bootloader interface {
+	// Name identifies the backend, e.g. "grub", "uboot", "sdboot".
+	Name() string
+	// Detect reports whether this backend is the one installed on the
+	// running system.
+	Detect() bool
+	// SetNextSlot points the next boot at slot, using kernel/initrd/cmdline
+	// as the entry to boot (initrd may be empty for backends that don't
+	// need one, e.g. a UKI-only sdboot entry).
+	SetNextSlot(slot string, kernel, initrd, cmdline string) error
+	// ReadCmdline returns the kernel command line the system was booted
+	// with, e.g. the contents of /proc/cmdline.
+	ReadCmdline() (string, error)
+	// KernelReleaseFromCmdline extracts the kernel release string from a
+	// command line in this backend's own format.
+	KernelReleaseFromCmdline(cmdline string) string
+}
+
+// configFile is the override file consulted by Select before falling back
+// to detection order.
+const configFile = "/etc/deepin-ab-recovery.conf"
+
+// detectionOrder lists the backends probed, in order, when no override is
+// configured. grub remains first since it covers the large majority of
+// existing installs.
+func detectionOrder() []Bootloader {
+	return []Bootloader{
+		NewGrub(),
+		NewUboot(),
+		NewSdboot(),
+	}
+}
+
+// Select returns the Bootloader to use: the one named by the
+// "Bootloader=" key in configFile if present, otherwise the first backend
+// in detectionOrder whose Detect returns true.
+func Select() (Bootloader, error) {
+	backends := detectionOrder()
+
+	if name, err := readConfigOverride(configFile); err == nil && name != "" {
+		for _, b := range backends {
+			if b.Name() == name {
+				return b, nil
+			}
+		}
+		return nil, fmt.Errorf("bootloader: configured backend %q is not known", name)
+	}
+
+	for _, b := range backends {
+		if b.Detect() {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("bootloader: no supported backend detected")
+}
+
+var bootloaderConfigRegexp = regexp.MustCompile(`(?m)^\s*Bootloader\s*=\s*(\S+)\s*$`)
+
+// readConfigOverride reads the "Bootloader=" key from filename, returning
+// "" and the read error if the file is absent, or "" and nil if the key
+// isn't set.
+func readConfigOverride(filename string) (string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	match := bootloaderConfigRegexp.FindStringSubmatch(string(data))
+	if match == nil {
+		return "", nil
+	}
+	return strings.TrimSpace(match[1]), nil
+}
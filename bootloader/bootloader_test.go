@@ -0,0 +1,66 @@
+package bootloader
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKernelReleaseFromCmdline(t *testing.T) {
+	cases := []struct {
+		name    string
+		b       Bootloader
+		cmdline string
+		want    string
+	}{
+		{
+			name:    "grub amd64",
+			b:       NewGrub(),
+			cmdline: "BOOT_IMAGE=/boot/vmlinuz-4.19.0-6-amd64 root=UUID=f18109bb-57ab-4b0f-8bae-a000e59e720a ro splash quiet",
+			want:    "4.19.0-6-amd64",
+		},
+		{
+			name:    "grub option order varies",
+			b:       NewGrub(),
+			cmdline: "root=UUID=f18109bb-57ab-4b0f-8bae-a000e59e720a ro BOOT_IMAGE=/boot/vmlinuz-4.19.0-6-amd64 splash quiet",
+			want:    "4.19.0-6-amd64",
+		},
+		{
+			name:    "grub arm64 desktop",
+			b:       NewGrub(),
+			cmdline: "BOOT_IMAGE=/vmlinuz-4.19.0-arm64-desktop root=UUID=f436eb5f-f471-42d9-b750-49987284e4f5 ro splash quiet",
+			want:    "4.19.0-arm64-desktop",
+		},
+		{
+			name:    "uboot bootargs",
+			b:       NewUboot(),
+			cmdline: "BOOT_IMAGE=/boot/vmlinuz-4.19.0-loongson3 root=/dev/mapper/vg0-Roota ro console=ttyS0,115200",
+			want:    "4.19.0-loongson3",
+		},
+		{
+			name:    "sdboot options line",
+			b:       NewSdboot(),
+			cmdline: "BOOT_IMAGE=/boot/vmlinuz-5.15.0-efi root=/dev/nvme0n1p4 ro quiet splash",
+			want:    "5.15.0-efi",
+		},
+		{
+			name:    "no BOOT_IMAGE present",
+			b:       NewGrub(),
+			cmdline: "root=/dev/sda1 ro quiet",
+			want:    "",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := c.b.KernelReleaseFromCmdline(c.cmdline)
+			assert.Equal(t, c.want, got)
+		})
+	}
+}
+
+func TestSelectConfigOverride(t *testing.T) {
+	name, err := readConfigOverride("/nonexistent/deepin-ab-recovery.conf")
+	assert.NotNil(t, err)
+	assert.Equal(t, "", name)
+}
@@ -0,0 +1,72 @@
+package bootloader
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+)
+
+// grubDefaultDir is where deepin-ab-recovery drops its generated
+// configuration snippet for grub-mkconfig to pick up.
+const grubDefaultDir = "/etc/default/grub.d"
+
+// Grub is the original, and still most common, backend: it edits a
+// snippet under /etc/default/grub.d and reruns update-grub to regenerate
+// grub.cfg.
+type Grub struct{}
+
+// NewGrub returns the grub backend.
+func NewGrub() *Grub {
+	return &Grub{}
+}
+
+func (g *Grub) Name() string {
+	return "grub"
+}
+
+// Detect reports whether grub.cfg is present, which is true on every
+// existing deepin-ab-recovery install predating this refactor.
+func (g *Grub) Detect() bool {
+	_, err := os.Stat("/boot/grub/grub.cfg")
+	return err == nil
+}
+
+// SetNextSlot writes a grub.d snippet selecting slot's menu entry and
+// cmdline, and reruns update-grub so the change takes effect immediately.
+// kernel and initrd are not written into the snippet.
+func (g *Grub) SetNextSlot(slot string, kernel, initrd, cmdline string) error {
+	snippet := fmt.Sprintf(
+		"GRUB_DEFAULT=\"gnulinux-advanced-%s\"\nGRUB_CMDLINE_LINUX=\"%s\"\n",
+		slot, cmdline)
+
+	if err := os.MkdirAll(grubDefaultDir, 0755); err != nil {
+		return err
+	}
+	filename := grubDefaultDir + "/90-deepin-ab-recovery"
+	if err := os.WriteFile(filename, []byte(snippet), 0644); err != nil {
+		return err
+	}
+
+	return exec.Command("update-grub").Run()
+}
+
+func (g *Grub) ReadCmdline() (string, error) {
+	data, err := os.ReadFile("/proc/cmdline")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+var grubBootImageRegexp = regexp.MustCompile(`BOOT_IMAGE=\S*/vmlinuz-(\S+)`)
+
+// KernelReleaseFromCmdline parses the BOOT_IMAGE= option grub passes on
+// the kernel command line.
+func (g *Grub) KernelReleaseFromCmdline(cmdline string) string {
+	match := grubBootImageRegexp.FindStringSubmatch(cmdline)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
@@ -0,0 +1,88 @@
+package bootloader
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// espLoaderEntriesDir is where systemd-boot looks for boot entries on the
+// EFI system partition.
+const espLoaderEntriesDir = "/boot/efi/loader/entries"
+
+// Sdboot targets UEFI-only images that use systemd-boot rather than grub.
+// Each slot gets its own entries file, e.g. "roota.conf" / "rootb.conf",
+// and SetNextSlot rewrites loader/loader.conf's "default" key to select
+// it. A slot whose backup produced a UKI points "linux" at that single
+// .efi file instead of separate linux/initrd lines (see the UKI-aware
+// findKernelFiles changes).
+type Sdboot struct{}
+
+// NewSdboot returns the systemd-boot backend.
+func NewSdboot() *Sdboot {
+	return &Sdboot{}
+}
+
+func (s *Sdboot) Name() string {
+	return "sdboot"
+}
+
+// Detect reports whether systemd-boot's loader directory is present.
+func (s *Sdboot) Detect() bool {
+	_, err := os.Stat(espLoaderEntriesDir)
+	return err == nil
+}
+
+// entryName returns the systemd-boot entry id for slot, e.g. "roota".
+func entryName(slot string) string {
+	return "root" + slot
+}
+
+// SetNextSlot writes (or rewrites) the slot's entries file and points
+// loader.conf's default entry at it. If kernel points at a UKI (see the
+// UKI-aware kernel file discovery), initrd is expected to be empty and
+// the entry's "linux" line names the UKI directly.
+func (s *Sdboot) SetNextSlot(slot string, kernel, initrd, cmdline string) error {
+	if err := os.MkdirAll(espLoaderEntriesDir, 0755); err != nil {
+		return err
+	}
+
+	var entry strings.Builder
+	fmt.Fprintf(&entry, "title deepin-ab-recovery %s\n", slot)
+	fmt.Fprintf(&entry, "linux %s\n", kernel)
+	if initrd != "" {
+		fmt.Fprintf(&entry, "initrd %s\n", initrd)
+	}
+	fmt.Fprintf(&entry, "options %s\n", cmdline)
+
+	entryFile := espLoaderEntriesDir + "/" + entryName(slot) + ".conf"
+	if err := os.WriteFile(entryFile, []byte(entry.String()), 0644); err != nil {
+		return err
+	}
+
+	loaderConf := fmt.Sprintf("default %s.conf\ntimeout 3\n", entryName(slot))
+	return os.WriteFile("/boot/efi/loader/loader.conf", []byte(loaderConf), 0644)
+}
+
+func (s *Sdboot) ReadCmdline() (string, error) {
+	data, err := os.ReadFile("/proc/cmdline")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+var sdbootImageRegexp = regexp.MustCompile(`BOOT_IMAGE=\S*/vmlinuz-(\S+)`)
+
+// KernelReleaseFromCmdline parses the BOOT_IMAGE= option systemd-boot
+// forwards unchanged from the entry's "options" line. Only a BOOT_IMAGE=
+// naming a vmlinuz-<release> file is recognised; a cmdline whose
+// BOOT_IMAGE= names a UKI yields "", and no .uname section is read.
+func (s *Sdboot) KernelReleaseFromCmdline(cmdline string) string {
+	match := sdbootImageRegexp.FindStringSubmatch(cmdline)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
@@ -0,0 +1,84 @@
+package bootloader
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+)
+
+// ubootEnvFile is where deepin-ab-recovery writes its own env fragment;
+// ubootBootScr is the boot script written alongside it, which sets
+// bootargs itself and does not load ubootEnvFile.
+const (
+	ubootEnvFile = "/boot/uboot.env"
+	ubootBootScr = "/boot/boot.scr"
+)
+
+// Uboot targets the Loongson/ARM64 boards (e.g. the LEMOTE LS3A3000
+// boards seen in parseBoardInfo fixtures) that ship u-boot instead of
+// grub. It writes a small env fragment and regenerates boot.scr; where
+// available it also pokes the live environment with fw_setenv so a
+// pending change takes effect without a reboot of the bootloader itself.
+type Uboot struct{}
+
+// NewUboot returns the u-boot backend.
+func NewUboot() *Uboot {
+	return &Uboot{}
+}
+
+func (u *Uboot) Name() string {
+	return "uboot"
+}
+
+// Detect reports whether this looks like a u-boot system: either
+// fw_setenv is installed, or a uboot.env is already present.
+func (u *Uboot) Detect() bool {
+	if _, err := exec.LookPath("fw_setenv"); err == nil {
+		return true
+	}
+	_, err := os.Stat(ubootEnvFile)
+	return err == nil
+}
+
+// SetNextSlot writes slot's kernel/initrd/cmdline into the env fragment
+// and cmdline into boot.scr as bootargs, then sets ab_slot in the live
+// u-boot environment with fw_setenv when that tool is available; the
+// kernel, initrd and cmdline are not pushed through fw_setenv.
+func (u *Uboot) SetNextSlot(slot string, kernel, initrd, cmdline string) error {
+	env := fmt.Sprintf("ab_slot=%s\nab_kernel=%s\nab_initrd=%s\nab_cmdline=%s\n",
+		slot, kernel, initrd, cmdline)
+	if err := os.WriteFile(ubootEnvFile, []byte(env), 0644); err != nil {
+		return err
+	}
+
+	script := fmt.Sprintf("setenv bootargs '%s'\nbooti ${loadaddr} ${initrd} ${fdt_addr}\n", cmdline)
+	if err := os.WriteFile(ubootBootScr, []byte(script), 0644); err != nil {
+		return err
+	}
+
+	if _, err := exec.LookPath("fw_setenv"); err == nil {
+		return exec.Command("fw_setenv", "ab_slot", slot).Run()
+	}
+	return nil
+}
+
+func (u *Uboot) ReadCmdline() (string, error) {
+	data, err := os.ReadFile("/proc/cmdline")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+var ubootBootImageRegexp = regexp.MustCompile(`BOOT_IMAGE=\S*/vmlinuz-(\S+)`)
+
+// KernelReleaseFromCmdline parses the BOOT_IMAGE= option written into
+// bootargs by SetNextSlot above.
+func (u *Uboot) KernelReleaseFromCmdline(cmdline string) string {
+	match := ubootBootImageRegexp.FindStringSubmatch(cmdline)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
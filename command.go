@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/linuxdeepin/deepin-ab-recovery/progress"
+)
+
+// mountPointFor is where doBackup/doRestore mount the standby slot's
+// partition while they work on it.
+var mountPointFor = filepath.Join(varLibDir, "mnt")
+
+// extraDirs lists directories backed up independently of the main rsync
+// pass via backupExtraDir/restoreExtraDir, e.g. because they live on a
+// bind mount rsync -aAX wouldn't otherwise traverse the same way on both
+// slots. Empty by default; installs that need one populate it from their
+// own packaging.
+var extraDirs []string
+
+// hospiceDir holds the extra-dir copies made by doBackup, on the running
+// slot's own storage so it survives the standby slot being overwritten by
+// the next rsync pass.
+var hospiceDir = filepath.Join(varLibDir, "hospice")
+
+// doBackup mirrors the running slot onto the standby slot: it mounts the
+// standby partition, rsyncs the root filesystem onto it, copies
+// extraDirs into the hospice, regenerates the bootloader config and
+// finally points the next boot at the standby slot, recording a pending
+// boot marker so the watchdog can roll back if it never comes up.
+func doBackup(p *progress.Progress) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("doBackup: load config: %w", err)
+	}
+	targetSlot := otherSlot(cfg.CurrentSlot)
+
+	targetDevice, err := slotDevice(cfg, targetSlot)
+	if err != nil {
+		return fmt.Errorf("doBackup: %w", err)
+	}
+	if err := mountSlot(targetDevice, mountPointFor); err != nil {
+		return fmt.Errorf("doBackup: mount %s: %w", targetDevice, err)
+	}
+	defer unmountSlot(mountPointFor)
+
+	if err := rsyncBackup("/", mountPointFor, p); err != nil {
+		return fmt.Errorf("doBackup: rsync: %w", err)
+	}
+
+	for _, dir := range extraDirs {
+		if err := backupExtraDir(dir, "", hospiceDir, p); err != nil {
+			return fmt.Errorf("doBackup: backup %s: %w", dir, err)
+		}
+	}
+
+	if err := updateGrubConfig(p); err != nil {
+		return fmt.Errorf("doBackup: update grub config: %w", err)
+	}
+
+	cmdline, err := os.ReadFile("/proc/cmdline")
+	if err != nil {
+		return fmt.Errorf("doBackup: read cmdline: %w", err)
+	}
+	kernelRelease := getKernelReleaseWithBootOption(string(cmdline))
+	if err := setNextBootSlot(targetSlot, kernelRelease, string(cmdline)); err != nil {
+		return fmt.Errorf("doBackup: set next boot slot: %w", err)
+	}
+
+	return writePendingBoot(targetSlot, cfg.CurrentSlot, kernelRelease, string(cmdline))
+}
+
+// doRestore is the inverse of doBackup: it mounts the standby slot and
+// rsyncs it back onto the running root, restoring extraDirs from the
+// hospice, then regenerates the bootloader config.
+func doRestore(p *progress.Progress) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("doRestore: load config: %w", err)
+	}
+	standbySlot := otherSlot(cfg.CurrentSlot)
+
+	standbyDevice, err := slotDevice(cfg, standbySlot)
+	if err != nil {
+		return fmt.Errorf("doRestore: %w", err)
+	}
+	if err := mountSlot(standbyDevice, mountPointFor); err != nil {
+		return fmt.Errorf("doRestore: mount %s: %w", standbyDevice, err)
+	}
+	defer unmountSlot(mountPointFor)
+
+	if err := rsyncBackup(mountPointFor, "/", p); err != nil {
+		return fmt.Errorf("doRestore: rsync: %w", err)
+	}
+
+	for _, dir := range extraDirs {
+		if err := restoreExtraDir(dir, "", hospiceDir, p); err != nil {
+			return fmt.Errorf("doRestore: restore %s: %w", dir, err)
+		}
+	}
+
+	return updateGrubConfig(p)
+}
+
+// slotDevice resolves slot to a device path via the partition UUID
+// recorded for it in cfg.
+func slotDevice(cfg abConfig, slot string) (string, error) {
+	uuid := cfg.SlotUUIDs[slot]
+	if uuid == "" {
+		return "", fmt.Errorf("no partition UUID configured for slot %q", slot)
+	}
+	path, err := getPathByUUID(uuid)
+	if err != nil {
+		return "", fmt.Errorf("resolve uuid %s for slot %q: %w", uuid, slot, err)
+	}
+	if path == "" {
+		return "", fmt.Errorf("no block device found for slot %q (uuid %s)", slot, uuid)
+	}
+	return path, nil
+}
+
+// mountSlot mounts device at mountPoint, unless something is already
+// mounted there. It goes through execCommand, like the grub-mkstandalone
+// and unsquashfs calls in iso.go, so callers (doBackup/doRestore and the
+// ISO restore path) can be tested without a real mount.
+func mountSlot(device, mountPoint string) error {
+	mounted, err := isMounted(mountPoint)
+	if err != nil {
+		return err
+	}
+	if mounted {
+		return nil
+	}
+	if err := os.MkdirAll(mountPoint, 0755); err != nil {
+		return err
+	}
+	return execCommand("mount", device, mountPoint).Run()
+}
+
+// unmountSlot unmounts mountPoint.
+func unmountSlot(mountPoint string) error {
+	return execCommand("umount", mountPoint).Run()
+}
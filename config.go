@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// configFile records which partition backs each slot, so doBackup/
+// doRestore know what to mount without depending on a fixed device name.
+// This is distinct from the bootloader package's own
+// /etc/deepin-ab-recovery.conf, which only overrides backend detection.
+const configFile = "/etc/deepin-ab-recovery.json"
+
+// abConfig is the on-disk configuration read by doBackup/doRestore.
+type abConfig struct {
+	// CurrentSlot is the slot this config was written from; the other
+	// slot is always the backup/restore target.
+	CurrentSlot string `json:"currentSlot"`
+	// SlotUUIDs maps a slot name to the filesystem UUID of the partition
+	// backing it, resolved to a device path via getPathByUUID.
+	SlotUUIDs map[string]string `json:"slotUUIDs"`
+}
+
+// loadConfig reads and parses configFile.
+func loadConfig() (abConfig, error) {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return abConfig{}, err
+	}
+	var cfg abConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return abConfig{}, err
+	}
+	return cfg, nil
+}
+
+// saveConfig writes cfg to configFile.
+func saveConfig(cfg abConfig) error {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(configFile, data, 0644)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestNewProgressFallsBackWithoutDBus exercises newProgress in an
+// environment with no reachable system bus (as in this test run): it must
+// still return a usable Progress, falling back to TTY-only reporting
+// rather than failing the caller's backup/restore.
+func TestNewProgressFallsBackWithoutDBus(t *testing.T) {
+	p, stop := newProgress()
+	require.NotNil(t, p)
+	defer stop()
+
+	assert.NotPanics(t, func() {
+		p.Report("test", 1, 2, "msg")
+	})
+}
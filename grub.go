@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os/exec"
+
+	"github.com/linuxdeepin/deepin-ab-recovery/bootloader"
+	"github.com/linuxdeepin/deepin-ab-recovery/progress"
+)
+
+// updateGrubConfig reruns update-grub to regenerate grub.cfg, reporting a
+// single "grub" stage Event before and after since, unlike rsync,
+// update-grub gives no incremental progress of its own to parse. Only the
+// grub backend needs this extra step: u-boot and systemd-boot apply their
+// next-slot change as part of SetNextSlot itself, with no separate
+// top-level config to regenerate.
+func updateGrubConfig(p *progress.Progress) error {
+	b, err := bootloader.Select()
+	if err != nil {
+		return err
+	}
+	if _, ok := b.(*bootloader.Grub); !ok {
+		return nil
+	}
+
+	if p != nil {
+		p.Report("grub", 0, 1, "regenerating grub.cfg")
+	}
+	err = exec.Command("update-grub").Run()
+	if p != nil {
+		p.Report("grub", 1, 1, "grub.cfg regenerated")
+	}
+	return err
+}
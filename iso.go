@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// restoreCmdlineArg is appended to the GRUB menu entry's kernel command
+// line for the "Restore this system" entry generated by buildISO, and
+// recognized in /proc/cmdline by restoreFromISOIfRequested at startup.
+const restoreCmdlineArg = "deepin-ab-recovery.restore=1"
+
+// restoreTargetCmdlinePrefix names the kernel argument carrying the
+// partition to unpack the embedded squashfs onto, e.g.
+// "deepin-ab-recovery.restore-target=/dev/sda2". It names a partition
+// rather than a whole disk because unpackSquashfsOnto mounts it directly,
+// the same way mountSlot in command.go mounts a slot's partition rather
+// than the disk it lives on. There is no single correct value to bake in
+// at ISO build time -- which partition to restore onto depends on the
+// machine being recovered -- so the "Restore this system" entry ships a
+// placeholder the operator edits (GRUB's "e" key) to the right partition
+// before booting it.
+const restoreTargetCmdlinePrefix = "deepin-ab-recovery.restore-target="
+
+// restoreTargetPlaceholder is the value staged into grub.cfg for the
+// operator to replace.
+const restoreTargetPlaceholder = "/dev/sdX1"
+
+// execCommand is exec.Command by default; tests replace it with a
+// fake-exec helper that reinvokes the test binary as TestHelperProcess
+// instead of actually running grub-mkstandalone/grub-mkimage/xorriso.
+var execCommand = exec.Command
+
+// isoStageLayout is the staging tree buildISO assembles before handing it
+// to xorriso; kept as a struct so tests can assert on the paths without
+// re-deriving them.
+type isoStageLayout struct {
+	root       string
+	bootDir    string // <root>/boot, holds kernel/initrd and grub
+	squashfs   string // <root>/live/filesystem.squashfs
+	coreImg    string // BIOS grub-mkstandalone output
+	bootx64efi string // UEFI grub-mkimage output
+}
+
+func newISOStageLayout(root string) isoStageLayout {
+	return isoStageLayout{
+		root:       root,
+		bootDir:    filepath.Join(root, "boot"),
+		squashfs:   filepath.Join(root, "live", "filesystem.squashfs"),
+		coreImg:    filepath.Join(root, "boot", "grub", "i386-pc", "core.img"),
+		bootx64efi: filepath.Join(root, "EFI", "BOOT", "bootx64.efi"),
+	}
+}
+
+// buildISO implements `deepin-ab-recovery build-iso <output.iso>`: it
+// stages the currently-running slot's kernel, initrd and a squashfs of the
+// root filesystem alongside a minimal GRUB config, then drives
+// grub-mkstandalone/grub-mkimage and xorriso to assemble a bootable
+// hybrid BIOS+UEFI ISO.
+func buildISO(kernelRelease, rootDir, output string) error {
+	tempDir, err := ioutil.TempDir("", "deepin-ab-recovery-iso")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	layout := newISOStageLayout(tempDir)
+	if err := stageISO(layout, kernelRelease, rootDir); err != nil {
+		return err
+	}
+
+	arm := isARMOrLoongArchBoard()
+
+	if !arm {
+		if err := runGrubMkstandalone(layout); err != nil {
+			return err
+		}
+	}
+	if err := runGrubMkimage(layout, arm); err != nil {
+		return err
+	}
+
+	return runXorriso(layout, output, arm)
+}
+
+// isARMOrLoongArchBoard reports whether the running board is an
+// aarch64/loongarch system, judged from uname's machine field alone (the
+// dmidecode board info read by parseBoardInfo is not consulted), in which
+// case the BIOS eltorito entry is skipped and GRUB is built for the
+// matching EFI target instead of x86_64-efi.
+func isARMOrLoongArchBoard() bool {
+	utsName, err := uname()
+	if err != nil {
+		return false
+	}
+	switch utsName.machine {
+	case "aarch64", "loongarch64":
+		return true
+	default:
+		return false
+	}
+}
+
+func grubTargetFor(arm bool) string {
+	if arm {
+		if utsName, err := uname(); err == nil && utsName.machine == "loongarch64" {
+			return "loongarch64-efi"
+		}
+		return "arm64-efi"
+	}
+	return "x86_64-efi"
+}
+
+func stageISO(layout isoStageLayout, kernelRelease, rootDir string) error {
+	if err := os.MkdirAll(layout.bootDir, 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(layout.squashfs), 0755); err != nil {
+		return err
+	}
+
+	kf, err := findKernelFiles(kernelRelease, "")
+	if err != nil {
+		return fmt.Errorf("buildISO: locate kernel files: %w", err)
+	}
+	if kf.uki != "" {
+		if err := copyFileTo(kf.uki, filepath.Join(layout.bootDir, filepath.Base(kf.uki))); err != nil {
+			return err
+		}
+	} else {
+		if err := copyFileTo(kf.linux, filepath.Join(layout.bootDir, filepath.Base(kf.linux))); err != nil {
+			return err
+		}
+		if kf.initrd != "" {
+			if err := copyFileTo(kf.initrd, filepath.Join(layout.bootDir, filepath.Base(kf.initrd))); err != nil {
+				return err
+			}
+		}
+	}
+
+	grubCfg := grubMenuEntry(kf, restoreCmdlineArg)
+	grubCfgDir := filepath.Join(layout.bootDir, "grub")
+	if err := os.MkdirAll(grubCfgDir, 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(grubCfgDir, "grub.cfg"), []byte(grubCfg), 0644); err != nil {
+		return err
+	}
+
+	return execCommand("mksquashfs", rootDir, layout.squashfs, "-noappend").Run()
+}
+
+// grubMenuEntry builds the "Restore this system" menuentry staged into
+// grub.cfg. A Unified Kernel Image bundles the kernel, initrd and cmdline
+// into one signed EFI binary, so it is chainloaded directly rather than
+// booted via separate linux/initrd directives, which would otherwise
+// point at the empty kf.linux/kf.initrd the UKI case leaves unset.
+func grubMenuEntry(kf kernelFiles, restoreCmdlineArg string) string {
+	if kf.uki != "" {
+		return fmt.Sprintf(`menuentry "Restore this system" {
+	chainloader /boot/%s
+}
+`, filepath.Base(kf.uki))
+	}
+
+	return fmt.Sprintf(`menuentry "Restore this system" {
+	linux /boot/%s root=live:LABEL=deepin-ab-recovery %s %s%s
+	initrd /boot/%s
+}
+`, filepath.Base(kf.linux), restoreCmdlineArg, restoreTargetCmdlinePrefix, restoreTargetPlaceholder, filepath.Base(kf.initrd))
+}
+
+func copyFileTo(src, dst string) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0644)
+}
+
+func runGrubMkstandalone(layout isoStageLayout) error {
+	if err := os.MkdirAll(filepath.Dir(layout.coreImg), 0755); err != nil {
+		return err
+	}
+	return execCommand("grub-mkstandalone",
+		"--format=i386-pc",
+		"--output="+layout.coreImg,
+		"boot/grub/grub.cfg="+filepath.Join(layout.bootDir, "grub", "grub.cfg"),
+	).Run()
+}
+
+func runGrubMkimage(layout isoStageLayout, arm bool) error {
+	if err := os.MkdirAll(filepath.Dir(layout.bootx64efi), 0755); err != nil {
+		return err
+	}
+	return execCommand("grub-mkimage",
+		"--format="+grubTargetFor(arm),
+		"--output="+layout.bootx64efi,
+		"--prefix=/boot/grub",
+	).Run()
+}
+
+func runXorriso(layout isoStageLayout, output string, arm bool) error {
+	args := []string{
+		"-as", "mkisofs",
+		"-isohybrid-mbr", layout.coreImg,
+		"-eltorito-alt-boot",
+		"-e", "EFI/BOOT/bootx64.efi",
+		"-no-emul-boot",
+		"-o", output,
+		layout.root,
+	}
+	if arm {
+		// aarch64/loongarch boards boot UEFI-only; there is no BIOS
+		// eltorito entry to chain, so isohybrid-mbr is dropped too.
+		args = []string{
+			"-as", "mkisofs",
+			"-e", "EFI/BOOT/bootx64.efi",
+			"-no-emul-boot",
+			"-o", output,
+			layout.root,
+		}
+	}
+	return execCommand("xorriso", args...).Run()
+}
+
+// cmdlineHasFlag reports whether cmdline contains flag as a standalone
+// whitespace-separated field.
+func cmdlineHasFlag(cmdline, flag string) bool {
+	for _, field := range splitFields(cmdline) {
+		if field == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreTargetFromCmdline extracts the partition named by
+// restoreTargetCmdlinePrefix from cmdline, or "" if it was never set (e.g.
+// the operator booted the "Restore this system" entry without first
+// editing its placeholder).
+func restoreTargetFromCmdline(cmdline string) string {
+	for _, field := range splitFields(cmdline) {
+		if strings.HasPrefix(field, restoreTargetCmdlinePrefix) {
+			return strings.TrimPrefix(field, restoreTargetCmdlinePrefix)
+		}
+	}
+	return ""
+}
+
+// restoreFromISOIfRequested checks cmdline for restoreCmdlineArg and, if
+// present, unpacks the ISO's embedded squashfs onto targetPartition. It
+// does not re-run the A/B setup afterwards. It is called from main at
+// startup via maybeRestoreFromISO.
+func restoreFromISOIfRequested(cmdline, targetPartition string) (bool, error) {
+	if !cmdlineHasFlag(cmdline, restoreCmdlineArg) {
+		return false, nil
+	}
+	return true, unpackSquashfsOnto("/run/initramfs/live/live/filesystem.squashfs", targetPartition)
+}
+
+// restoreMountPointFor is where unpackSquashfsOnto mounts targetPartition
+// while unsquashfs writes the restored system onto it: unsquashfs -d takes
+// a destination directory, not a raw partition, so targetPartition (as
+// named on the GRUB cmdline by the operator) has to be mounted first. It
+// is a var, like mountPointFor in command.go, so tests can point it at a
+// fixture directory instead of this live-environment path.
+var restoreMountPointFor = "/run/deepin-ab-recovery/restore-mnt"
+
+func unpackSquashfsOnto(squashfs, targetPartition string) error {
+	if err := mountSlot(targetPartition, restoreMountPointFor); err != nil {
+		return fmt.Errorf("unpackSquashfsOnto: mount %s: %w", targetPartition, err)
+	}
+	defer unmountSlot(restoreMountPointFor)
+
+	return execCommand("unsquashfs", "-f", "-d", restoreMountPointFor, squashfs).Run()
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeExecCommand replaces exec.Command for the duration of a test with
+// one that reinvokes the test binary as `-test.run=TestHelperProcess`,
+// recording argv to argvLog so assertions can inspect what would have
+// been run without touching the real grub-mkstandalone/xorriso tools.
+func fakeExecCommand(argvLog *[]string) func(name string, args ...string) *exec.Cmd {
+	return func(name string, args ...string) *exec.Cmd {
+		*argvLog = append(*argvLog, strings.Join(append([]string{name}, args...), " "))
+		cs := append([]string{"-test.run=TestHelperProcess", "--", name}, args...)
+		cmd := exec.Command(os.Args[0], cs...)
+		cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1")
+		return cmd
+	}
+}
+
+// TestHelperProcess isn't a real test; it's the fake subprocess body
+// invoked by fakeExecCommand above, following the standard
+// os/exec-mocking pattern.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	defer os.Exit(0)
+
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	if len(args) > 0 {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return
+	}
+
+	switch args[0] {
+	case "grub-mkstandalone", "grub-mkimage":
+		for i, a := range args {
+			if strings.HasPrefix(a, "--output=") {
+				out := strings.TrimPrefix(a, "--output=")
+				os.MkdirAll(filepath.Dir(out), 0755)
+				ioutil.WriteFile(out, []byte("fake"), 0644)
+				_ = i
+			}
+		}
+	case "xorriso":
+		for i, a := range args {
+			if a == "-o" && i+1 < len(args) {
+				ioutil.WriteFile(args[i+1], []byte("fake iso"), 0644)
+			}
+		}
+	case "mksquashfs":
+		if len(args) >= 3 {
+			ioutil.WriteFile(args[2], []byte("fake squashfs"), 0644)
+		}
+	}
+}
+
+func TestBuildISOStaging(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "buildISOTest")
+	require.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	origBootDir := globalBootDir
+	globalBootDir = filepath.Join(tempDir, "boot")
+	require.Nil(t, os.MkdirAll(globalBootDir, 0755))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(globalBootDir, "vmlinuz-5.10.0-amd64"), []byte("k"), 0644))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(globalBootDir, "initrd.img-5.10.0-amd64"), []byte("i"), 0644))
+	defer func() { globalBootDir = origBootDir }()
+
+	var argvLog []string
+	origExecCommand := execCommand
+	execCommand = fakeExecCommand(&argvLog)
+	defer func() { execCommand = origExecCommand }()
+
+	output := filepath.Join(tempDir, "recovery.iso")
+	err = buildISO("5.10.0-amd64", tempDir, output)
+	assert.Nil(t, err)
+
+	_, err = os.Stat(output)
+	assert.Nil(t, err, "xorriso output must exist")
+
+	var sawMksquashfs, sawGrubMkimage, sawXorriso bool
+	for _, argv := range argvLog {
+		if strings.HasPrefix(argv, "mksquashfs") {
+			sawMksquashfs = true
+		}
+		if strings.HasPrefix(argv, "grub-mkimage") {
+			sawGrubMkimage = true
+			assert.Contains(t, argv, "--format=x86_64-efi")
+		}
+		if strings.HasPrefix(argv, "xorriso") {
+			sawXorriso = true
+			assert.Contains(t, argv, fmt.Sprintf("-o %s", output))
+		}
+	}
+	assert.True(t, sawMksquashfs)
+	assert.True(t, sawGrubMkimage)
+	assert.True(t, sawXorriso)
+}
+
+// TestBuildISOStagingUKI exercises the UKI branch of stageISO: the
+// staged grub.cfg must chainloader the UKI directly, since kf.linux and
+// kf.initrd are both empty in this case.
+func TestBuildISOStagingUKI(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "buildISOStagingUKITest")
+	require.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	origBootDir := globalBootDir
+	globalBootDir = filepath.Join(tempDir, "boot")
+	require.Nil(t, os.MkdirAll(globalBootDir, 0755))
+	defer func() { globalBootDir = origBootDir }()
+
+	efiDir := filepath.Join(tempDir, "EFI", "Linux")
+	require.Nil(t, os.MkdirAll(efiDir, 0755))
+	uki := buildFakeUKI("5.15.0-uki-amd64", "root=UUID=abc ro quiet")
+	require.Nil(t, ioutil.WriteFile(filepath.Join(efiDir, "deepin-5.15.0-uki-amd64.efi"), uki, 0644))
+
+	origDirs := ukiSearchDirs
+	ukiSearchDirs = []string{efiDir}
+	defer func() { ukiSearchDirs = origDirs }()
+
+	var argvLog []string
+	origExecCommand := execCommand
+	execCommand = fakeExecCommand(&argvLog)
+	defer func() { execCommand = origExecCommand }()
+
+	layout := newISOStageLayout(tempDir)
+	err = stageISO(layout, "5.15.0-uki-amd64", tempDir)
+	assert.Nil(t, err)
+
+	cfg, err := ioutil.ReadFile(filepath.Join(layout.bootDir, "grub", "grub.cfg"))
+	require.Nil(t, err)
+	assert.Contains(t, string(cfg), "chainloader /boot/deepin-5.15.0-uki-amd64.efi")
+	assert.NotContains(t, string(cfg), "linux /boot/.")
+	assert.NotContains(t, string(cfg), "initrd /boot/.")
+}
+
+func TestGrubMenuEntryHasRestoreTargetPlaceholder(t *testing.T) {
+	cfg := grubMenuEntry(kernelFiles{linux: "vmlinuz-5.10.0-amd64", initrd: "initrd.img-5.10.0-amd64"}, restoreCmdlineArg)
+	assert.Contains(t, cfg, restoreCmdlineArg)
+	assert.Contains(t, cfg, restoreTargetCmdlinePrefix+restoreTargetPlaceholder,
+		"operator must have a restore-target argument to edit before booting the entry")
+}
+
+func TestRestoreTargetFromCmdline(t *testing.T) {
+	target := restoreTargetFromCmdline("root=live:LABEL=deepin-ab-recovery " + restoreCmdlineArg +
+		" " + restoreTargetCmdlinePrefix + "/dev/nvme0n1p2 ro quiet")
+	assert.Equal(t, "/dev/nvme0n1p2", target)
+
+	target = restoreTargetFromCmdline("root=live:LABEL=deepin-ab-recovery " + restoreCmdlineArg + " ro quiet")
+	assert.Equal(t, "", target, "no restore-target argument set")
+}
+
+func TestRestoreFromISOIfRequested(t *testing.T) {
+	restoring, err := restoreFromISOIfRequested("root=/dev/sda1 ro quiet", "/dev/sdb")
+	assert.Nil(t, err)
+	assert.False(t, restoring)
+
+	tempDir, err := ioutil.TempDir("", "restoreFromISOTest")
+	require.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	origMountPoint := restoreMountPointFor
+	restoreMountPointFor = filepath.Join(tempDir, "restore-mnt")
+	defer func() { restoreMountPointFor = origMountPoint }()
+
+	var argvLog []string
+	origExecCommand := execCommand
+	execCommand = fakeExecCommand(&argvLog)
+	defer func() { execCommand = origExecCommand }()
+
+	restoring, err = restoreFromISOIfRequested(
+		"root=live:LABEL=deepin-ab-recovery "+restoreCmdlineArg+" ro quiet", "/dev/sdb")
+	assert.Nil(t, err)
+	assert.True(t, restoring)
+
+	var sawMount, sawUnsquashfs, sawUmount bool
+	for _, argv := range argvLog {
+		switch {
+		case strings.HasPrefix(argv, "mount "):
+			sawMount = true
+			assert.Contains(t, argv, "/dev/sdb")
+		case strings.HasPrefix(argv, "unsquashfs"):
+			sawUnsquashfs = true
+			assert.Contains(t, argv, restoreMountPointFor, "unsquashfs must target the mounted directory, not the raw disk")
+		case strings.HasPrefix(argv, "umount "):
+			sawUmount = true
+		}
+	}
+	assert.True(t, sawMount, "targetPartition must be mounted before unsquashfs runs")
+	assert.True(t, sawUnsquashfs)
+	assert.True(t, sawUmount)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/linuxdeepin/deepin-ab-recovery/bootloader"
+)
+
+// varLibDir is where pending_boot.json and other runtime state live; it is
+// a var, like globalBootDir, so tests can point it at a fixture directory.
+var varLibDir = "/var/lib/deepin-ab-recovery"
+
+const (
+	slotA = "a"
+	slotB = "b"
+)
+
+func main() {
+	maybeRestoreFromISO()
+
+	if len(os.Args) < 2 {
+		log.Fatal("usage: deepin-ab-recovery <backup|restore|confirm|count-boot-attempt|build-iso>")
+	}
+
+	switch os.Args[1] {
+	case "backup":
+		p, stop := newProgress()
+		defer stop()
+		if err := doBackup(p); err != nil {
+			log.Fatal(err)
+		}
+	case "restore":
+		p, stop := newProgress()
+		defer stop()
+		if err := doRestore(p); err != nil {
+			log.Fatal(err)
+		}
+	case "confirm":
+		if err := confirmBoot(); err != nil {
+			log.Fatal(err)
+		}
+	case "count-boot-attempt":
+		if err := recordBootAttempt(); err != nil {
+			log.Fatal(err)
+		}
+	case "build-iso":
+		if len(os.Args) < 3 {
+			log.Fatal("usage: deepin-ab-recovery build-iso <output.iso>")
+		}
+		if err := buildISOForRunningSlot(os.Args[2]); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+		os.Exit(1)
+	}
+}
+
+// buildISOForRunningSlot builds a recovery ISO of the currently-running
+// slot at output, determining the kernel release from the live cmdline.
+func buildISOForRunningSlot(output string) error {
+	cmdline, err := os.ReadFile("/proc/cmdline")
+	if err != nil {
+		return err
+	}
+	kernelRelease := getKernelReleaseWithBootOption(string(cmdline))
+	if kernelRelease == "" {
+		return fmt.Errorf("buildISOForRunningSlot: could not determine running kernel release")
+	}
+	return buildISO(kernelRelease, "/", output)
+}
+
+// maybeRestoreFromISO is called unconditionally at the start of main and,
+// when booted from a recovery ISO built by buildISO with its "Restore
+// this system" GRUB entry, unpacks the embedded squashfs onto the
+// partition the operator named via restoreTargetCmdlinePrefix instead of
+// running the normal A/B commands.
+func maybeRestoreFromISO() {
+	cmdline, err := os.ReadFile("/proc/cmdline")
+	if err != nil {
+		return
+	}
+	if !cmdlineHasFlag(string(cmdline), restoreCmdlineArg) {
+		return
+	}
+
+	targetPartition := restoreTargetFromCmdline(string(cmdline))
+	if targetPartition == "" || targetPartition == restoreTargetPlaceholder {
+		log.Fatalf("deepin-ab-recovery: booted with %s but no %s<partition> was set; edit the GRUB entry (press e) and set it to the partition to restore onto",
+			restoreCmdlineArg, restoreTargetCmdlinePrefix)
+	}
+
+	restoring, err := restoreFromISOIfRequested(string(cmdline), targetPartition)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if restoring {
+		os.Exit(0)
+	}
+}
+
+// otherSlot returns the slot that is not slot.
+func otherSlot(slot string) string {
+	if slot == slotA {
+		return slotB
+	}
+	return slotA
+}
+
+// setNextBootSlot points the next boot at slot, using kernelRelease to
+// locate the kernel (or UKI) to boot and cmdline as its command line. It
+// goes through bootloader.Select so u-boot and systemd-boot installs get
+// their own backend's behavior instead of grub-only handling. It is a var,
+// like execCommand in iso.go, so recordBootAttempt's rollback path can be
+// exercised in tests without driving a real bootloader.
+var setNextBootSlot = func(slot, kernelRelease, cmdline string) error {
+	b, err := bootloader.Select()
+	if err != nil {
+		return err
+	}
+	kf, err := findKernelFiles(kernelRelease, "")
+	if err != nil {
+		return err
+	}
+	kernel, initrd := kf.linux, kf.initrd
+	if kf.uki != "" {
+		kernel = kf.uki
+	}
+	return b.SetNextSlot(slot, kernel, initrd, cmdline)
+}
@@ -0,0 +1,47 @@
+// Package progress reports the status of long-running deepin-ab-recovery
+// operations (a full-partition rsync, copying the extra dirs, regenerating
+// the bootloader config) to whichever callers are listening, following the
+// same Progress pattern used by the Talos imager.
+package progress
+
+// Event describes one point-in-time update of a long-running operation.
+type Event struct {
+	Stage   string
+	Current int64
+	Total   int64
+	Message string
+}
+
+// Reporter receives Events as an operation advances. Implementations must
+// be safe to call from the goroutine driving the operation; Report should
+// not block for long since it is called frequently (e.g. once per rsync
+// progress line).
+type Reporter interface {
+	Report(stage string, current, total int64, message string)
+}
+
+// Progress fans a stream of Events out to zero or more Reporters, e.g. the
+// DBus service's Progress signal and a TTY renderer for CLI callers.
+type Progress struct {
+	reporters []Reporter
+}
+
+// New returns a Progress that forwards to reporters.
+func New(reporters ...Reporter) *Progress {
+	return &Progress{reporters: reporters}
+}
+
+// Report forwards stage/current/total/message to every registered
+// Reporter.
+func (p *Progress) Report(stage string, current, total int64, message string) {
+	for _, r := range p.reporters {
+		r.Report(stage, current, total, message)
+	}
+}
+
+// ReporterFunc adapts a plain function to the Reporter interface.
+type ReporterFunc func(stage string, current, total int64, message string)
+
+func (f ReporterFunc) Report(stage string, current, total int64, message string) {
+	f(stage, current, total, message)
+}
@@ -0,0 +1,92 @@
+package progress
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// rsyncProgress2Regexp matches one line of `rsync --info=progress2`
+// output, e.g.:
+//
+//	      1,234,567  42%    1.23MB/s    0:00:05 (xfr#3, to-chk=12/34)
+var rsyncProgress2Regexp = regexp.MustCompile(
+	`^\s*([\d,]+)\s+(\d+)%.*\(xfr#(\d+), to-chk=(\d+)/(\d+)\)\s*$`)
+
+// ParseRsyncProgress2Line parses one line of `--info=progress2` output,
+// returning the bytes transferred so far, the total file count, and how
+// many files are still to check, or ok=false if the line did not match
+// (rsync interleaves progress lines with other chatter).
+func ParseRsyncProgress2Line(line string) (bytesDone int64, filesDone, filesTotal int64, ok bool) {
+	match := rsyncProgress2Regexp.FindStringSubmatch(line)
+	if match == nil {
+		return 0, 0, 0, false
+	}
+
+	bytesDone, err := strconv.ParseInt(strings.ReplaceAll(match[1], ",", ""), 10, 64)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	xfr, err := strconv.ParseInt(match[3], 10, 64)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	toChk, err := strconv.ParseInt(match[4], 10, 64)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	total, err := strconv.ParseInt(match[5], 10, 64)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	return bytesDone, xfr, total - toChk + xfr, true
+}
+
+// ReportRsyncOutput reads `--info=progress2` lines from r and reports one
+// Event per line it can parse, under the given stage name.
+func ReportRsyncOutput(r io.Reader, p *Progress, stage string) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(bufio.ScanLines)
+	// rsync rewrites the progress line in place with '\r'; scan on either.
+	scanner.Split(scanLinesCR)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		bytesDone, filesDone, filesTotal, ok := ParseRsyncProgress2Line(line)
+		if !ok {
+			continue
+		}
+		p.Report(stage, bytesDone, filesTotal, formatFileCount(filesDone, filesTotal))
+	}
+	return scanner.Err()
+}
+
+func formatFileCount(done, total int64) string {
+	return strconv.FormatInt(done, 10) + "/" + strconv.FormatInt(total, 10) + " files"
+}
+
+// scanLinesCR is a bufio.SplitFunc like bufio.ScanLines but also splits on
+// a bare '\r', which is how rsync updates its progress line in place.
+func scanLinesCR(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	for i, b := range data {
+		if b == '\n' || b == '\r' {
+			return i + 1, trimCR(data[:i]), nil
+		}
+	}
+	if atEOF {
+		return len(data), trimCR(data), nil
+	}
+	return 0, nil, nil
+}
+
+func trimCR(data []byte) []byte {
+	if len(data) > 0 && data[len(data)-1] == '\r' {
+		return data[:len(data)-1]
+	}
+	return data
+}
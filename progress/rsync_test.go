@@ -0,0 +1,47 @@
+package progress
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRsyncProgress2Line(t *testing.T) {
+	bytesDone, filesDone, filesTotal, ok := ParseRsyncProgress2Line(
+		"      1,234,567  42%    1.23MB/s    0:00:05 (xfr#3, to-chk=12/34)")
+	assert.True(t, ok)
+	assert.EqualValues(t, 1234567, bytesDone)
+	assert.EqualValues(t, 3, filesDone)
+	assert.EqualValues(t, 25, filesTotal) // 34 - 12 + 3
+
+	_, _, _, ok = ParseRsyncProgress2Line("sending incremental file list")
+	assert.False(t, ok)
+
+	_, _, _, ok = ParseRsyncProgress2Line("")
+	assert.False(t, ok)
+}
+
+func TestReportRsyncOutput(t *testing.T) {
+	output := "sending incremental file list\n" +
+		"      1,000,000  10%    1.00MB/s    0:00:09 (xfr#1, to-chk=9/10)\r" +
+		"      5,000,000  50%    1.00MB/s    0:00:05 (xfr#5, to-chk=5/10)\r" +
+		"     10,000,000 100%    1.00MB/s    0:00:00 (xfr#10, to-chk=0/10)\n" +
+		"sent 10,000,100 bytes  received 123 bytes\n"
+
+	var events []Event
+	p := New(ReporterFunc(func(stage string, current, total int64, message string) {
+		events = append(events, Event{Stage: stage, Current: current, Total: total, Message: message})
+	}))
+
+	err := ReportRsyncOutput(strings.NewReader(output), p, "rsync")
+	assert.Nil(t, err)
+
+	require := assert.New(t)
+	require.Len(events, 3)
+	require.Equal(int64(1000000), events[0].Current)
+	require.Equal(int64(10000000), events[2].Current)
+	for _, e := range events {
+		require.Equal("rsync", e.Stage)
+	}
+}
@@ -0,0 +1,50 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// TTYReporter renders Events as a single updating line when stderr is a
+// terminal, falling back to one plain log line per Event otherwise (e.g.
+// when output is redirected to a file or piped to journald).
+type TTYReporter struct {
+	w      io.Writer
+	isatty bool
+}
+
+// NewTTYReporter returns a Reporter writing to w, auto-detecting whether w
+// is a terminal.
+func NewTTYReporter(w io.Writer) *TTYReporter {
+	isatty := false
+	if f, ok := w.(*os.File); ok {
+		isatty = term.IsTerminal(int(f.Fd()))
+	}
+	return &TTYReporter{w: w, isatty: isatty}
+}
+
+func (r *TTYReporter) Report(stage string, current, total int64, message string) {
+	if !r.isatty {
+		fmt.Fprintf(r.w, "[%s] %s\n", stage, message)
+		return
+	}
+
+	pct := 0
+	if total > 0 {
+		pct = int(current * 100 / total)
+		if pct > 100 {
+			pct = 100
+		}
+	}
+	fmt.Fprintf(r.w, "\r\033[K[%s] %3d%% %s", stage, pct, message)
+}
+
+// Done terminates the current updating line, if any.
+func (r *TTYReporter) Done() {
+	if r.isatty {
+		fmt.Fprintln(r.w)
+	}
+}
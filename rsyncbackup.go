@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+
+	"github.com/linuxdeepin/deepin-ab-recovery/progress"
+)
+
+// rsyncBackup mirrors srcRoot onto dstRoot using rsync, reporting byte and
+// file-count progress for stage "rsync" as it goes.
+func rsyncBackup(srcRoot, dstRoot string, p *progress.Progress) error {
+	cmd := exec.Command("rsync", "-aAX", "--delete", "--info=progress2",
+		srcRoot+"/", dstRoot+"/")
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	if err := progress.ReportRsyncOutput(stdout, p, "rsync"); err != nil {
+		return fmt.Errorf("rsyncBackup: read progress: %w", err)
+	}
+
+	return cmd.Wait()
+}
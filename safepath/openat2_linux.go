@@ -0,0 +1,87 @@
+package safepath
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// openBeneath opens rel relative to root, refusing to resolve through a
+// symlink that would escape root. It tries openat2(2) with
+// RESOLVE_NO_SYMLINKS|RESOLVE_BENEATH first (Linux 5.6+); on ENOSYS (older
+// kernels) it falls back to walkBeneath, a manual component-by-component
+// Openat walk that rejects symlinks itself.
+func openBeneath(root, rel string, flags int, mode os.FileMode) (int, error) {
+	rootFd, err := unix.Open(root, unix.O_DIRECTORY|unix.O_RDONLY, 0)
+	if err != nil {
+		return -1, err
+	}
+	defer unix.Close(rootFd)
+
+	how := unix.OpenHow{
+		Flags:   uint64(flags) | unix.O_CLOEXEC,
+		Mode:    uint64(mode),
+		Resolve: unix.RESOLVE_NO_SYMLINKS | unix.RESOLVE_BENEATH,
+	}
+	fd, err := unix.Openat2(rootFd, normalizeRel(rel), &how)
+	if err == unix.ENOSYS {
+		return walkBeneath(rootFd, rel, flags, mode)
+	}
+	if err != nil {
+		return -1, err
+	}
+	return fd, nil
+}
+
+func normalizeRel(rel string) string {
+	rel = filepath.Clean(rel)
+	if rel == "." {
+		return "."
+	}
+	return strings.TrimPrefix(rel, "/")
+}
+
+// walkBeneath resolves rel relative to rootFd one path component at a
+// time, opening each intermediate directory with O_NOFOLLOW so that no
+// component is ever allowed to be a symlink. This is the fallback used on
+// kernels without openat2(2).
+func walkBeneath(rootFd int, rel string, flags int, mode os.FileMode) (int, error) {
+	rel = normalizeRel(rel)
+	if rel == "." {
+		return unix.Dup(rootFd)
+	}
+
+	parts := strings.Split(rel, "/")
+	dirFd := rootFd
+	closeDirFd := false
+	defer func() {
+		if closeDirFd {
+			unix.Close(dirFd)
+		}
+	}()
+
+	for i, part := range parts {
+		last := i == len(parts)-1
+		componentFlags := unix.O_NOFOLLOW
+		if !last {
+			componentFlags |= unix.O_DIRECTORY | unix.O_RDONLY
+		} else {
+			componentFlags |= flags
+		}
+
+		fd, err := unix.Openat(dirFd, part, componentFlags, uint32(mode))
+		if err != nil {
+			return -1, err
+		}
+		if closeDirFd {
+			unix.Close(dirFd)
+		}
+		dirFd = fd
+		closeDirFd = true
+	}
+
+	closeDirFd = false
+	return dirFd, nil
+}
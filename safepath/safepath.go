@@ -0,0 +1,238 @@
+// Package safepath provides filesystem helpers that never follow a
+// symlink they did not create themselves. Every operation is rooted at a
+// Path so that a malicious symlink planted under a directory this process
+// backs up (or restores into) as root cannot redirect a read or write
+// outside of that tree.
+package safepath
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Path is a directory resolved once, beneath which all further opens are
+// resolved without following symlinks out of the tree.
+type Path struct {
+	root string
+	rel  string
+}
+
+// NewPath roots a new Path at dir. dir itself is trusted (it is typically
+// created by this process), everything below it is not.
+func NewPath(dir string) *Path {
+	return &Path{root: dir}
+}
+
+// Join returns a Path for rel beneath p, without touching the filesystem.
+func (p *Path) Join(rel string) *Path {
+	return &Path{root: p.root, rel: filepath.Join(p.rel, rel)}
+}
+
+// String returns the path for diagnostics. It must not be used to open
+// the file directly, since doing so would race and could follow a
+// symlink swapped in between the String call and the open.
+func (p *Path) String() string {
+	return filepath.Join(p.root, p.rel)
+}
+
+// OpenAt opens p for reading, refusing to resolve through any symlink
+// rooted outside of p.root. On kernels new enough to support it this uses
+// openat2(RESOLVE_NO_SYMLINKS|RESOLVE_BENEATH); otherwise it falls back to
+// a manual component-by-component walk using Openat.
+func OpenAt(p *Path) (*os.File, error) {
+	fd, err := openBeneath(p.root, p.rel, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, pathErr("open", p, err)
+	}
+	return os.NewFile(uintptr(fd), p.String()), nil
+}
+
+// CreateAt creates (or truncates) a regular file at p.
+func CreateAt(p *Path, mode os.FileMode) (*os.File, error) {
+	fd, err := openBeneath(p.root, p.rel, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, mode)
+	if err != nil {
+		return nil, pathErr("create", p, err)
+	}
+	return os.NewFile(uintptr(fd), p.String()), nil
+}
+
+// MkdirAt creates the directory at p if it does not already exist.
+func MkdirAt(p *Path, mode os.FileMode) error {
+	dirFd, base, err := openParentBeneath(p.root, p.rel)
+	if err != nil {
+		return pathErr("mkdirat", p, err)
+	}
+	defer syscall.Close(dirFd)
+
+	err = syscall.Mkdirat(dirFd, base, uint32(mode))
+	if err != nil && err != syscall.EEXIST {
+		return pathErr("mkdirat", p, err)
+	}
+	return nil
+}
+
+// SymlinkAt creates a symlink at p pointing at target. target is stored
+// verbatim and is never itself resolved by this call.
+func SymlinkAt(target string, p *Path) error {
+	dirFd, base, err := openParentBeneath(p.root, p.rel)
+	if err != nil {
+		return pathErr("symlinkat", p, err)
+	}
+	defer syscall.Close(dirFd)
+
+	if err := unix.Symlinkat(target, dirFd, base); err != nil {
+		return pathErr("symlinkat", p, err)
+	}
+	return nil
+}
+
+// ReadlinkAt reads the target of the symlink at p without following it.
+func ReadlinkAt(p *Path) (string, error) {
+	dirFd, base, err := openParentBeneath(p.root, p.rel)
+	if err != nil {
+		return "", pathErr("readlinkat", p, err)
+	}
+	defer syscall.Close(dirFd)
+
+	buf := make([]byte, 4096)
+	n, err := unix.Readlinkat(dirFd, base, buf)
+	if err != nil {
+		return "", pathErr("readlinkat", p, err)
+	}
+	return string(buf[:n]), nil
+}
+
+// IsSymlinkAt reports whether p is itself a symlink (it does not follow
+// the link).
+func IsSymlinkAt(p *Path) (bool, error) {
+	dirFd, base, err := openParentBeneath(p.root, p.rel)
+	if err != nil {
+		return false, pathErr("lstatat", p, err)
+	}
+	defer syscall.Close(dirFd)
+
+	var stat unix.Stat_t
+	if err := unix.Fstatat(dirFd, base, &stat, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return false, pathErr("lstatat", p, err)
+	}
+	return stat.Mode&unix.S_IFMT == unix.S_IFLNK, nil
+}
+
+// UnlinkAt removes the file or symlink at p. It does not remove
+// directories: unlinkat is called without AT_REMOVEDIR.
+func UnlinkAt(p *Path) error {
+	dirFd, base, err := openParentBeneath(p.root, p.rel)
+	if err != nil {
+		return pathErr("unlinkat", p, err)
+	}
+	defer syscall.Close(dirFd)
+
+	if err := unix.Unlinkat(dirFd, base, 0); err != nil {
+		return pathErr("unlinkat", p, err)
+	}
+	return nil
+}
+
+// CopyTree recursively copies src to dst, both rooted Paths, never
+// traversing a symlink it did not itself create: a symlink found under
+// src is recreated as a symlink under dst (with the same, unresolved,
+// target string) rather than being dereferenced and copied through.
+func CopyTree(dst, src *Path) error {
+	return CopyTreeFunc(dst, src, nil)
+}
+
+// CopyTreeFunc is CopyTree with an optional filter: when keep is non-nil
+// it is called with each entry's path relative to src, and entries for
+// which it returns false are skipped entirely.
+func CopyTreeFunc(dst, src *Path, keep func(rel string) bool) error {
+	return copyTreeRel(dst, src, "", keep)
+}
+
+func copyTreeRel(dst, src *Path, rel string, keep func(rel string) bool) error {
+	srcFile, err := OpenAt(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	entries, err := srcFile.Readdir(-1)
+	if err != nil {
+		return fmt.Errorf("safepath: readdir %s: %w", src, err)
+	}
+
+	if err := MkdirAt(dst, 0755); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		entryRel := filepath.Join(rel, entry.Name())
+		if keep != nil && !keep(entryRel) {
+			continue
+		}
+		srcChild := src.Join(entry.Name())
+		dstChild := dst.Join(entry.Name())
+
+		if entry.Mode()&os.ModeSymlink != 0 {
+			target, err := ReadlinkAt(srcChild)
+			if err != nil {
+				return err
+			}
+			if err := SymlinkAt(target, dstChild); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if entry.IsDir() {
+			if err := copyTreeRel(dstChild, srcChild, entryRel, keep); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := copyFile(dstChild, srcChild, entry.Mode()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(dst, src *Path, mode os.FileMode) error {
+	in, err := OpenAt(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := CreateAt(dst, mode)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
+
+func pathErr(op string, p *Path, err error) error {
+	return fmt.Errorf("safepath: %s %s: %w", op, p, err)
+}
+
+// openParentBeneath resolves the parent directory of rel beneath root
+// (never following a symlink out of root) and returns an open fd to it
+// plus the final path component, for use with the *at syscalls.
+func openParentBeneath(root, rel string) (dirFd int, base string, err error) {
+	rel = filepath.Clean(rel)
+	dir, base := filepath.Split(rel)
+	fd, err := openBeneath(root, strings.TrimSuffix(dir, "/"), os.O_RDONLY|syscall.O_DIRECTORY, 0)
+	if err != nil {
+		return -1, "", err
+	}
+	return fd, base, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os/exec"
+	"regexp"
+	"strings"
+	"syscall"
+)
+
+// boardInfo holds the subset of dmidecode's BIOS Information block this
+// package cares about, used to recognize specific boards (e.g. the
+// Loongson Kunlun boards that need uboot rather than grub) that can't be
+// told apart purely from uname.
+type boardInfo struct {
+	biosVersion string
+}
+
+// getBoardInfo runs dmidecode and parses its BIOS Information block.
+func getBoardInfo() (boardInfo, error) {
+	out, err := exec.Command("dmidecode", "-t", "bios").Output()
+	if err != nil {
+		return boardInfo{}, err
+	}
+	return parseBoardInfo(out), nil
+}
+
+// parseBoardInfo extracts the "Version" field of dmidecode's BIOS
+// Information block from data.
+func parseBoardInfo(data []byte) boardInfo {
+	var info boardInfo
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := splitColonField(scanner.Text())
+		if ok && key == "Version" {
+			info.biosVersion = value
+			break
+		}
+	}
+	return info
+}
+
+// lsb_release key names, matching the left-hand side of
+// `lsb_release -a`'s output.
+const (
+	lsbReleaseKeyDistID   = "Distributor ID"
+	lsbReleaseKeyDesc     = "Description"
+	lsbReleaseKeyRelease  = "Release"
+	lsbReleaseKeyCodename = "Codename"
+)
+
+// getLsbReleaseInfo runs `lsb_release -a` and parses its output.
+func getLsbReleaseInfo() (map[string]string, error) {
+	out, err := exec.Command("lsb_release", "-a").Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseLsbReleaseOutput(out), nil
+}
+
+// parseLsbReleaseOutput parses `lsb_release -a`-style "Key:\tvalue" lines
+// from data into a map keyed by the lsbReleaseKey* constants.
+func parseLsbReleaseOutput(data []byte) map[string]string {
+	info := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if key, value, ok := splitColonField(scanner.Text()); ok {
+			info[key] = value
+		}
+	}
+	return info
+}
+
+// splitColonField splits a dmidecode/lsb_release style "Key: value" (or
+// "Key:\tvalue") line, trimming surrounding whitespace from both sides.
+func splitColonField(line string) (key, value string, ok bool) {
+	parts := strings.SplitN(line, ":", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), true
+}
+
+// isMounted reports whether mountPoint appears as a mount point in
+// /proc/mounts.
+func isMounted(mountPoint string) (bool, error) {
+	data, err := exec.Command("cat", "/proc/mounts").Output()
+	if err != nil {
+		return false, err
+	}
+	return isMountedAux(data, mountPoint), nil
+}
+
+// isMountedAux implements isMounted against /proc/mounts-style data
+// directly, so it can be exercised without touching the filesystem.
+func isMountedAux(data []byte, mountPoint string) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := splitFields(scanner.Text())
+		if len(fields) >= 2 && fields[1] == mountPoint {
+			return true
+		}
+	}
+	return false
+}
+
+// utsNameInfo holds the two uname(2) fields this package cares about,
+// already decoded from their raw char arrays.
+type utsNameInfo struct {
+	machine string
+	release string
+}
+
+// uname wraps the uname(2) syscall, decoding the machine and release
+// fields from their null-terminated char arrays.
+func uname() (utsNameInfo, error) {
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err != nil {
+		return utsNameInfo{}, err
+	}
+	return utsNameInfo{
+		machine: charsToString(uts.Machine[:]),
+		release: charsToString(uts.Release[:]),
+	}, nil
+}
+
+// charsToString converts a null-terminated []int8, as used by
+// syscall.Utsname's fields, to a string.
+func charsToString(chars []int8) string {
+	buf := make([]byte, 0, len(chars))
+	for _, c := range chars {
+		if c == 0 {
+			break
+		}
+		buf = append(buf, byte(c))
+	}
+	return string(buf)
+}
+
+var lsblkLineRegexp = regexp.MustCompile(`UUID="([^"]*)"\s+PATH="([^"]*)"`)
+
+// getPathByUUID runs `lsblk -o UUID,PATH -P` and returns the device path
+// whose UUID matches uuid, or "" if none does.
+func getPathByUUID(uuid string) (string, error) {
+	out, err := exec.Command("lsblk", "-o", "UUID,PATH", "-P").Output()
+	if err != nil {
+		return "", err
+	}
+	return getPathFromLsblkOutput(string(out), uuid), nil
+}
+
+// getPathFromLsblkOutput implements getPathByUUID against the raw
+// `lsblk -o UUID,PATH -P` output, so it can be exercised without touching
+// the filesystem.
+func getPathFromLsblkOutput(output, uuid string) string {
+	if uuid == "" {
+		return ""
+	}
+	for _, line := range strings.Split(output, "\n") {
+		match := lsblkLineRegexp.FindStringSubmatch(line)
+		if match != nil && match[1] == uuid {
+			return match[2]
+		}
+	}
+	return ""
+}
+
+// getOtherOSes runs os-prober and returns the device paths of the Linux
+// systems it found (other than this one), for the GRUB_DISABLE_OS_PROBER
+// integration.
+func getOtherOSes() ([]string, error) {
+	out, err := exec.Command("os-prober").Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseOsProberOutput(out), nil
+}
+
+// parseOsProberOutput parses os-prober's
+// "<device>:<long name>:<short name>:<type>" output, keeping only the
+// entries of type "linux".
+func parseOsProberOutput(data []byte) []string {
+	var result []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) >= 4 && fields[3] == "linux" {
+			result = append(result, fields[0])
+		}
+	}
+	return result
+}
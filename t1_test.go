@@ -8,6 +8,8 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/linuxdeepin/deepin-ab-recovery/bootloader"
+	"github.com/linuxdeepin/deepin-ab-recovery/safepath"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -139,14 +141,54 @@ func TestFindKernelFiles(t *testing.T) {
 }
 
 func TestGetKernelReleaseWithBootOption(t *testing.T) {
-	result := getKernelReleaseWithBootOption("BOOT_IMAGE=/boot/vmlinuz-4.19.0-6-amd64 root=UUID=f18109bb-57ab-4b0f-8bae-a000e59e720a ro splash quiet DEEPIN_GFXMODE=0,1920x1080,1152x864,1600x1200,1280x1024,1024x768")
-	assert.Equal(t, "4.19.0-6-amd64", result)
+	cases := []struct {
+		name    string
+		b       bootloader.Bootloader
+		cmdline string
+		want    string
+	}{
+		{
+			name:    "grub BOOT_IMAGE first",
+			b:       bootloader.NewGrub(),
+			cmdline: "BOOT_IMAGE=/boot/vmlinuz-4.19.0-6-amd64 root=UUID=f18109bb-57ab-4b0f-8bae-a000e59e720a ro splash quiet DEEPIN_GFXMODE=0,1920x1080,1152x864,1600x1200,1280x1024,1024x768",
+			want:    "4.19.0-6-amd64",
+		},
+		{
+			name:    "grub BOOT_IMAGE in the middle",
+			b:       bootloader.NewGrub(),
+			cmdline: "root=UUID=f18109bb-57ab-4b0f-8bae-a000e59e720a ro BOOT_IMAGE=/boot/vmlinuz-4.19.0-6-amd64 splash quiet DEEPIN_GFXMODE=0,1920x1080,1152x864,1600x1200,1280x1024,1024x768",
+			want:    "4.19.0-6-amd64",
+		},
+		{
+			name:    "grub arm64 desktop, long cmdline",
+			b:       bootloader.NewGrub(),
+			cmdline: "BOOT_IMAGE=/vmlinuz-4.19.0-arm64-desktop root=UUID=f436eb5f-f471-42d9-b750-49987284e4f5 ro splash earlycon=pl011,0xFFF02000 maxcpus=8 initcall_debug=y printktimer=0xfa89b000,0x534,0x538 rcupdate.rcu_expedited=1 buildvariant=eng pmu_nv_addr=0x0 boardid=0x2456 normal_reset_type=fastbootd boot_slice=0x107573 reboot_reason=COLD_BOOT exception_subtype=no last_bootup_keypoint=38 swiotlb=2 dma_zone_only=true kce_status=0 efuse_status=2 nokaslr hhee_enable=false console=ttyAMA6,115200 console=tty quiet loglevel=0 systemd.debug-shell=1 DEEPIN_GFXMODE=",
+			want:    "4.19.0-arm64-desktop",
+		},
+		{
+			name:    "uboot bootargs on a Loongson board",
+			b:       bootloader.NewUboot(),
+			cmdline: "BOOT_IMAGE=/boot/vmlinuz-4.19.0-loongson3 root=/dev/mapper/vg0-Roota ro console=ttyS0,115200",
+			want:    "4.19.0-loongson3",
+		},
+		{
+			name:    "systemd-boot options line",
+			b:       bootloader.NewSdboot(),
+			cmdline: "BOOT_IMAGE=/boot/vmlinuz-5.15.0-efi root=/dev/nvme0n1p4 ro quiet splash",
+			want:    "5.15.0-efi",
+		},
+	}
 
-	result = getKernelReleaseWithBootOption("root=UUID=f18109bb-57ab-4b0f-8bae-a000e59e720a ro BOOT_IMAGE=/boot/vmlinuz-4.19.0-6-amd64 splash quiet DEEPIN_GFXMODE=0,1920x1080,1152x864,1600x1200,1280x1024,1024x768")
-	assert.Equal(t, "4.19.0-6-amd64", result)
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.want, c.b.KernelReleaseFromCmdline(c.cmdline))
+		})
+	}
 
-	result = getKernelReleaseWithBootOption("BOOT_IMAGE=/vmlinuz-4.19.0-arm64-desktop root=UUID=f436eb5f-f471-42d9-b750-49987284e4f5 ro splash earlycon=pl011,0xFFF02000 maxcpus=8 initcall_debug=y printktimer=0xfa89b000,0x534,0x538 rcupdate.rcu_expedited=1 buildvariant=eng pmu_nv_addr=0x0 boardid=0x2456 normal_reset_type=fastbootd boot_slice=0x107573 reboot_reason=COLD_BOOT exception_subtype=no last_bootup_keypoint=38 swiotlb=2 dma_zone_only=true kce_status=0 efuse_status=2 nokaslr hhee_enable=false console=ttyAMA6,115200 console=tty quiet loglevel=0 systemd.debug-shell=1 DEEPIN_GFXMODE=")
-	assert.Equal(t, "4.19.0-arm64-desktop", result)
+	// getKernelReleaseWithBootOption remains as a grub-flavored convenience
+	// wrapper for callers that have not moved to the bootloader package yet.
+	assert.Equal(t, "4.19.0-6-amd64", getKernelReleaseWithBootOption(
+		"BOOT_IMAGE=/boot/vmlinuz-4.19.0-6-amd64 root=UUID=f18109bb-57ab-4b0f-8bae-a000e59e720a ro splash quiet"))
 }
 
 const lsblkUuidPath1 = `UUID="" PATH="/dev/sda"
@@ -206,11 +248,13 @@ func TestIsSymlink(t *testing.T) {
 	err = os.Symlink(f1, f2)
 	assert.Nil(t, err)
 
-	isSym, err := isSymlink(f1)
+	dir := safepath.NewPath(tempDir)
+
+	isSym, err := isSymlink(dir.Join("f1"))
 	assert.Nil(t, err)
 	assert.False(t, isSym)
 
-	isSym, err = isSymlink(f2)
+	isSym, err = isSymlink(dir.Join("f2"))
 	assert.Nil(t, err)
 	assert.True(t, isSym)
 }
@@ -236,12 +280,6 @@ func prepareDir(baseDir string, data map[string]string) error {
 }
 
 func TestBackupExtraDir(t *testing.T) {
-	_, err := exec.LookPath("cp")
-	if err != nil {
-		// backupExtraDir 依赖 cp 命令
-		t.Skip(err)
-	}
-
 	tempDir, err := ioutil.TempDir("", "backupExtraDirTest")
 	require.Nil(t, err)
 	defer func() {
@@ -312,3 +350,37 @@ func TestRestoreExtraDir(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, "ABC123", abc)
 }
+
+// TestBackupExtraDirSymlinkEscape plants a symlink inside the source tree
+// pointing at /etc/shadow and asserts that backing it up never opens that
+// target: the symlink itself is copied as a symlink, not followed.
+func TestBackupExtraDirSymlinkEscape(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "backupExtraDirEscapeTest")
+	require.Nil(t, err)
+	defer func() {
+		err := os.RemoveAll(tempDir)
+		if err != nil {
+			t.Logf("remove temp dir failed: %v", err)
+		}
+	}()
+
+	originDir := filepath.Join(tempDir, "/var/lib/xyz")
+	err = prepareDir(originDir, _testDataExtraDir)
+	require.Nil(t, err)
+
+	evilLink := filepath.Join(originDir, "evil")
+	err = os.Symlink("/etc/shadow", evilLink)
+	require.Nil(t, err)
+
+	hospiceDir := filepath.Join(tempDir, "hospice")
+	err = backupExtraDir(originDir, "", hospiceDir)
+	assert.Nil(t, err)
+
+	isSym, err := isSymlink(safepath.NewPath(hospiceDir).Join("xyz/evil"))
+	assert.Nil(t, err)
+	assert.True(t, isSym, "the backed up copy must still be a symlink, not /etc/shadow's contents")
+
+	target, err := os.Readlink(filepath.Join(hospiceDir, "xyz/evil"))
+	assert.Nil(t, err)
+	assert.Equal(t, "/etc/shadow", target)
+}
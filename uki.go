@@ -0,0 +1,87 @@
+package main
+
+import (
+	"debug/pe"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ukiSearchDirs are the locations Unified Kernel Images are looked for, in
+// order: the Debian/Deepin convention of an ESP bind-mounted under /boot,
+// and the convention of mounting the ESP directly at /efi.
+var ukiSearchDirs = []string{
+	"/boot/efi/EFI/Linux",
+	"/efi/EFI/Linux",
+}
+
+// findUKI scans ukiSearchDirs for a Unified Kernel Image whose embedded
+// .uname PE section matches kernelRelease, returning its path. A UKI is a
+// single PE binary bundling the kernel, initrd, cmdline and (optionally)
+// devicetree and splash, used by signed-boot setups in place of separate
+// vmlinuz-*/initrd.img-* files.
+func findUKI(kernelRelease string) (string, error) {
+	for _, dir := range ukiSearchDirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if !strings.HasSuffix(entry.Name(), ".efi") {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			release, _, err := readUKISections(path)
+			if err != nil {
+				continue
+			}
+			if release == kernelRelease {
+				return path, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no UKI found for release %q", kernelRelease)
+}
+
+// readUKISections opens the PE file at path and extracts the kernel
+// release (the ".uname" section) and kernel command line (the ".cmdline"
+// section) embedded by UKI build tooling such as systemd-ukify.
+func readUKISections(path string) (release, cmdline string, err error) {
+	f, err := pe.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	for _, section := range f.Sections {
+		switch section.Name {
+		case ".uname":
+			data, err := section.Data()
+			if err != nil {
+				return "", "", fmt.Errorf("%s: read .uname: %w", path, err)
+			}
+			release = trimPESectionString(data)
+		case ".cmdline":
+			data, err := section.Data()
+			if err != nil {
+				return "", "", fmt.Errorf("%s: read .cmdline: %w", path, err)
+			}
+			cmdline = trimPESectionString(data)
+		}
+	}
+	if release == "" {
+		return "", "", fmt.Errorf("%s: no .uname section found", path)
+	}
+	return release, cmdline, nil
+}
+
+// trimPESectionString cuts data at its first NUL byte, since PE section
+// data is padded to the section's raw size rather than being stored with
+// an explicit length.
+func trimPESectionString(data []byte) string {
+	if i := strings.IndexByte(string(data), 0); i >= 0 {
+		data = data[:i]
+	}
+	return string(data)
+}
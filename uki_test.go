@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildFakeUKI assembles the smallest PE/COFF file debug/pe will parse,
+// with a ".uname" section containing release and a ".cmdline" section
+// containing cmdline, in the shape systemd-ukify produces (section data
+// NUL-padded rather than length-prefixed).
+func buildFakeUKI(release, cmdline string) []byte {
+	const (
+		dosHeaderSize    = 64
+		peSignatureSize  = 4
+		fileHeaderSize   = 20
+		sectionHeaderSize = 40
+	)
+
+	unameData := append([]byte(release), 0)
+	cmdlineData := append([]byte(cmdline), 0)
+
+	sectionHeadersOffset := dosHeaderSize + peSignatureSize + fileHeaderSize
+	dataOffset := sectionHeadersOffset + 2*sectionHeaderSize
+	unameOffset := dataOffset
+	cmdlineOffset := unameOffset + len(unameData)
+
+	var buf bytes.Buffer
+
+	// DOS header: "MZ" magic, e_lfanew at offset 0x3c pointing past it.
+	dos := make([]byte, dosHeaderSize)
+	dos[0] = 'M'
+	dos[1] = 'Z'
+	binary.LittleEndian.PutUint32(dos[0x3c:], uint32(dosHeaderSize))
+	buf.Write(dos)
+
+	// PE signature.
+	buf.WriteString("PE\x00\x00")
+
+	// COFF file header; SizeOfOptionalHeader=0 so no optional header
+	// follows, keeping this fixture minimal.
+	fh := struct {
+		Machine              uint16
+		NumberOfSections     uint16
+		TimeDateStamp        uint32
+		PointerToSymbolTable uint32
+		NumberOfSymbols      uint32
+		SizeOfOptionalHeader uint16
+		Characteristics      uint16
+	}{
+		Machine:          0x8664,
+		NumberOfSections: 2,
+		Characteristics:  0x0002,
+	}
+	binary.Write(&buf, binary.LittleEndian, fh)
+
+	writeSection := func(name string, offset, size int) {
+		var nameBuf [8]byte
+		copy(nameBuf[:], name)
+		sh := struct {
+			Name                 [8]byte
+			VirtualSize          uint32
+			VirtualAddress       uint32
+			SizeOfRawData        uint32
+			PointerToRawData     uint32
+			PointerToRelocations uint32
+			PointerToLineNumbers uint32
+			NumberOfRelocations  uint16
+			NumberOfLineNumbers  uint16
+			Characteristics      uint32
+		}{
+			Name:             nameBuf,
+			VirtualSize:      uint32(size),
+			SizeOfRawData:    uint32(size),
+			PointerToRawData: uint32(offset),
+		}
+		binary.Write(&buf, binary.LittleEndian, sh)
+	}
+	writeSection(".uname", unameOffset, len(unameData))
+	writeSection(".cmdline", cmdlineOffset, len(cmdlineData))
+
+	buf.Write(unameData)
+	buf.Write(cmdlineData)
+
+	return buf.Bytes()
+}
+
+func TestFindKernelFilesUKI(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "findKernelFilesUKITest")
+	require.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	efiDir := filepath.Join(tempDir, "EFI", "Linux")
+	err = os.MkdirAll(efiDir, 0755)
+	require.Nil(t, err)
+
+	matching := buildFakeUKI("5.15.0-uki-amd64", "root=UUID=abc ro quiet")
+	err = ioutil.WriteFile(filepath.Join(efiDir, "deepin-5.15.0-uki-amd64.efi"), matching, 0644)
+	require.Nil(t, err)
+
+	other := buildFakeUKI("5.15.0-other-amd64", "root=UUID=def ro quiet")
+	err = ioutil.WriteFile(filepath.Join(efiDir, "deepin-5.15.0-other-amd64.efi"), other, 0644)
+	require.Nil(t, err)
+
+	origDirs := ukiSearchDirs
+	ukiSearchDirs = []string{efiDir}
+	defer func() { ukiSearchDirs = origDirs }()
+
+	path, err := findUKI("5.15.0-uki-amd64")
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(efiDir, "deepin-5.15.0-uki-amd64.efi"), path)
+
+	release, cmdline, err := readUKISections(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "5.15.0-uki-amd64", release)
+	assert.Equal(t, "root=UUID=abc ro quiet", cmdline)
+
+	_, err = findUKI("5.15.0-nonexistent")
+	assert.NotNil(t, err)
+}
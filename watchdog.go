@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxBootAttempts is the number of unconfirmed boots of the target slot
+// that are tolerated before the watchdog rolls back to the previous slot.
+const maxBootAttempts = 3
+
+var pendingBootFile = filepath.Join(varLibDir, "pending_boot.json")
+
+// pendingBoot records an in-progress slot switch that has not yet been
+// confirmed by a successful boot into a stable userspace.
+type pendingBoot struct {
+	TargetSlot   string `json:"targetSlot"`
+	PreviousSlot string `json:"previousSlot"`
+	// PreviousKernelRelease and PreviousCmdline are what the previous
+	// slot was booted with, kept so recordBootAttempt can point the
+	// bootloader back at it without needing to re-derive them at
+	// rollback time, when the target slot may be the only one left that
+	// can still be inspected.
+	PreviousKernelRelease string    `json:"previousKernelRelease"`
+	PreviousCmdline       string    `json:"previousCmdline"`
+	Attempt               int       `json:"attempt"`
+	Timestamp             time.Time `json:"timestamp"`
+}
+
+// writePendingBoot persists marker after main has pointed the bootloader
+// at targetSlot, so later boots of it (or the lack of one) can decide
+// whether the switch actually succeeded. Attempt starts at 0: it is
+// recordBootAttempt, not writePendingBoot, that counts the first boot of
+// targetSlot.
+func writePendingBoot(targetSlot, previousSlot, previousKernelRelease, previousCmdline string) error {
+	pb := pendingBoot{
+		TargetSlot:            targetSlot,
+		PreviousSlot:          previousSlot,
+		PreviousKernelRelease: previousKernelRelease,
+		PreviousCmdline:       previousCmdline,
+		Attempt:               0,
+		Timestamp:             time.Now(),
+	}
+	return savePendingBoot(pb)
+}
+
+func savePendingBoot(pb pendingBoot) error {
+	if err := os.MkdirAll(varLibDir, 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(pb)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(pendingBootFile, data, 0644)
+}
+
+// readPendingBoot loads the pending boot marker. ok is false if no switch
+// is currently pending.
+func readPendingBoot() (pb pendingBoot, ok bool, err error) {
+	data, err := os.ReadFile(pendingBootFile)
+	if os.IsNotExist(err) {
+		return pendingBoot{}, false, nil
+	}
+	if err != nil {
+		return pendingBoot{}, false, err
+	}
+	if err := json.Unmarshal(data, &pb); err != nil {
+		return pendingBoot{}, false, err
+	}
+	return pb, true, nil
+}
+
+// clearPendingBoot removes the pending boot marker, confirming that the
+// target slot is healthy.
+func clearPendingBoot() error {
+	err := os.Remove(pendingBootFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// confirmBoot is invoked by the deepin-ab-recovery-confirm.service unit
+// late in boot. If a slot switch is pending and no systemd unit is in the
+// failed state, the marker is cleared, confirming the target slot is
+// healthy. Every failed unit counts; there is no separate list of
+// critical units.
+// It never touches the attempt counter itself: recordBootAttempt, run far
+// earlier in boot by deepin-ab-recovery-boot-attempt.service, is solely
+// responsible for that, so a crash too early for this unit to ever run
+// still gets counted and eventually rolled back.
+func confirmBoot() error {
+	pb, ok, err := readPendingBoot()
+	if err != nil {
+		return fmt.Errorf("confirmBoot: read pending boot: %w", err)
+	}
+	if !ok {
+		// Nothing pending, a normal boot of the confirmed slot.
+		return nil
+	}
+
+	failed, err := failedSystemdUnits()
+	if err != nil {
+		return fmt.Errorf("confirmBoot: list failed units: %w", err)
+	}
+	if len(failed) == 0 {
+		return clearPendingBoot()
+	}
+
+	logRollbackWarning(fmt.Sprintf("boot attempt %d/%d for slot %s failed, units: %s",
+		pb.Attempt, maxBootAttempts, pb.TargetSlot, strings.Join(failed, ", ")))
+	return nil
+}
+
+// recordBootAttempt is invoked by the deepin-ab-recovery-boot-attempt.service
+// unit, ordered Before=sysinit.target so it runs on every boot of a
+// pending target slot even if that boot never makes it far enough for
+// deepin-ab-recovery-confirm.service to run at all. It bumps the attempt
+// counter unconditionally and, once it reaches maxBootAttempts, rolls
+// back to the previous slot immediately rather than waiting for a
+// confirm that will never come.
+func recordBootAttempt() error {
+	pb, ok, err := readPendingBoot()
+	if err != nil {
+		return fmt.Errorf("recordBootAttempt: read pending boot: %w", err)
+	}
+	if !ok {
+		// Nothing pending, a normal boot of the confirmed slot.
+		return nil
+	}
+
+	pb.Attempt++
+	if pb.Attempt < maxBootAttempts {
+		return savePendingBoot(pb)
+	}
+
+	logRollbackWarning(fmt.Sprintf("slot %s failed to confirm after %d attempts, rolling back to %s",
+		pb.TargetSlot, pb.Attempt, pb.PreviousSlot))
+	if err := setNextBootSlot(pb.PreviousSlot, pb.PreviousKernelRelease, pb.PreviousCmdline); err != nil {
+		return fmt.Errorf("recordBootAttempt: roll back to %s: %w", pb.PreviousSlot, err)
+	}
+	return clearPendingBoot()
+}
+
+// failedSystemdUnits returns the unit names reported by
+// `systemctl list-units --failed`. A unit stuck in a restart loop shows up
+// here even though the confirm service itself managed to run. It is a
+// var, like execCommand in iso.go and setNextBootSlot in main.go, so
+// confirmBoot's refuse-to-confirm behavior can be exercised in tests
+// without a real systemd to query.
+var failedSystemdUnits = func() ([]string, error) {
+	out, err := exec.Command("systemctl", "list-units", "--failed", "--no-legend", "--plain").Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseFailedUnits(out)
+}
+
+func parseFailedUnits(out []byte) ([]string, error) {
+	var units []string
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := splitFields(line)
+		units = append(units, fields[0])
+	}
+	return units, nil
+}
+
+// logRollbackWarning writes a watchdog failure message to stderr; it is a
+// thin wrapper so tests can redirect/inspect it later without touching the
+// call sites.
+func logRollbackWarning(msg string) {
+	fmt.Fprintln(os.Stderr, "deepin-ab-recovery: "+msg)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPendingBootRoundTrip(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "pendingBootTest")
+	require.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	origVarLibDir := varLibDir
+	origPendingBootFile := pendingBootFile
+	varLibDir = tempDir
+	pendingBootFile = filepath.Join(tempDir, "pending_boot.json")
+	defer func() {
+		varLibDir = origVarLibDir
+		pendingBootFile = origPendingBootFile
+	}()
+
+	_, ok, err := readPendingBoot()
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	err = writePendingBoot("b", "a", "4.19.0-6-amd64", "BOOT_IMAGE=/boot/vmlinuz-4.19.0-6-amd64")
+	assert.Nil(t, err)
+
+	pb, ok, err := readPendingBoot()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "b", pb.TargetSlot)
+	assert.Equal(t, "a", pb.PreviousSlot)
+	assert.Equal(t, "4.19.0-6-amd64", pb.PreviousKernelRelease)
+	assert.Equal(t, 0, pb.Attempt)
+
+	err = clearPendingBoot()
+	assert.Nil(t, err)
+
+	_, ok, err = readPendingBoot()
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+// TestRecordBootAttemptRollsBackWithoutConfirm exercises failure mode (a)
+// from the original request: an early-userspace crash that never lets
+// deepin-ab-recovery-confirm.service run. recordBootAttempt alone, called
+// once per boot by deepin-ab-recovery-boot-attempt.service, must still
+// notice and roll back once maxBootAttempts is reached, without
+// confirmBoot ever being invoked.
+func TestRecordBootAttemptRollsBackWithoutConfirm(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "recordBootAttemptTest")
+	require.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	origVarLibDir := varLibDir
+	origPendingBootFile := pendingBootFile
+	varLibDir = tempDir
+	pendingBootFile = filepath.Join(tempDir, "pending_boot.json")
+	defer func() {
+		varLibDir = origVarLibDir
+		pendingBootFile = origPendingBootFile
+	}()
+
+	var rolledBackTo string
+	origSetNextBootSlot := setNextBootSlot
+	setNextBootSlot = func(slot, kernelRelease, cmdline string) error {
+		rolledBackTo = slot
+		return nil
+	}
+	defer func() { setNextBootSlot = origSetNextBootSlot }()
+
+	require.Nil(t, writePendingBoot("b", "a", "4.19.0-6-amd64", "BOOT_IMAGE=/boot/vmlinuz-4.19.0-6-amd64"))
+
+	// Two early-boot crashes, neither ever reaching confirmBoot, must not
+	// yet trigger a rollback.
+	for i := 0; i < maxBootAttempts-1; i++ {
+		require.Nil(t, recordBootAttempt())
+		assert.Equal(t, "", rolledBackTo, "must not roll back before maxBootAttempts is reached")
+	}
+
+	// The attempt that reaches maxBootAttempts rolls back on its own,
+	// with no confirmBoot call anywhere in this test.
+	require.Nil(t, recordBootAttempt())
+	assert.Equal(t, "a", rolledBackTo)
+
+	_, ok, err := readPendingBoot()
+	assert.Nil(t, err)
+	assert.False(t, ok, "pending boot marker must be cleared once rolled back")
+}
+
+// TestConfirmBootRefusesOnFailedUnits exercises failure mode (b) from the
+// original request: confirmBoot must leave the pending boot marker in
+// place, rather than clearing it, when failedSystemdUnits reports any
+// failed unit.
+func TestConfirmBootRefusesOnFailedUnits(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "confirmBootRefusesTest")
+	require.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	origVarLibDir := varLibDir
+	origPendingBootFile := pendingBootFile
+	varLibDir = tempDir
+	pendingBootFile = filepath.Join(tempDir, "pending_boot.json")
+	defer func() {
+		varLibDir = origVarLibDir
+		pendingBootFile = origPendingBootFile
+	}()
+
+	origFailedSystemdUnits := failedSystemdUnits
+	failedSystemdUnits = func() ([]string, error) {
+		return []string{"some-flaky.service"}, nil
+	}
+	defer func() { failedSystemdUnits = origFailedSystemdUnits }()
+
+	require.Nil(t, writePendingBoot("b", "a", "4.19.0-6-amd64", "BOOT_IMAGE=/boot/vmlinuz-4.19.0-6-amd64"))
+
+	require.Nil(t, confirmBoot())
+
+	_, ok, err := readPendingBoot()
+	assert.Nil(t, err)
+	assert.True(t, ok, "pending boot marker must survive a failed confirm")
+}
+
+const failedUnitsOutput = `NetworkManager-wait-online.service loaded failed failed Network Manager Wait Online
+some-flaky.service       loaded failed failed Some flaky unit
+`
+
+func TestParseFailedUnits(t *testing.T) {
+	units, err := parseFailedUnits([]byte(failedUnitsOutput))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"NetworkManager-wait-online.service", "some-flaky.service"}, units)
+
+	units, err = parseFailedUnits([]byte(""))
+	assert.Nil(t, err)
+	assert.Len(t, units, 0)
+}
+
+// TestFindKernelFilesZeroSize exercises the zero-size kernel/initrd
+// detection on a real temp directory, in the style of TestFindKernelFiles
+// which drives findKernelFilesAux against a fixture name list.
+func TestFindKernelFilesZeroSize(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "findKernelFilesZeroSizeTest")
+	require.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	origBootDir := globalBootDir
+	globalBootDir = tempDir
+	defer func() { globalBootDir = origBootDir }()
+
+	err = ioutil.WriteFile(filepath.Join(tempDir, "vmlinuz-4.19.0-6-amd64"), nil, 0644)
+	require.Nil(t, err)
+	err = ioutil.WriteFile(filepath.Join(tempDir, "initrd.img-4.19.0-6-amd64"), []byte("x"), 0644)
+	require.Nil(t, err)
+
+	_, err = findKernelFiles("4.19.0-6-amd64", "x86_64")
+	assert.NotNil(t, err, "zero-size vmlinuz must be rejected")
+}